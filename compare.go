@@ -20,8 +20,6 @@ import (
 	"go/ast"
 	"go/types"
 	"strings"
-
-	"golang.org/x/tools/go/loader"
 )
 
 var comparePrefix = flag.String("compare.prefix", "deriveCompare", "set the prefix for compare functions that should be derived.")
@@ -32,22 +30,67 @@ type compare struct {
 	printer    Printer
 	bytesPkg   Import
 	stringsPkg Import
+	mathPkg    Import
+	reflectPkg Import
 	sortedKeys Plugin
+	// typeParamCmp maps the type parameters that are still unresolved for
+	// the named type currently being generated for to the name of the
+	// comparator parameter field should call to compare values of that
+	// type parameter. A type parameter is unresolved either because typ is
+	// the generic declaration itself (e.g. List in type List[T any] []T)
+	// or because typ is an instantiation made from inside another generic
+	// function, such as List[T] inside Merge[T any], whose own type
+	// argument is still T rather than a concrete type. typeParamCmp is
+	// populated by genFuncFor for the duration of a single function and is
+	// nil otherwise.
+	typeParamCmp map[*types.TypeParam]string
+	// namedTypes holds every concrete named type seen at a deriveCompare
+	// call site, so that interfaceCompare can dispatch dynamically typed
+	// interface field comparisons to whichever of them implement a given
+	// interface and have a derived Compare method.
+	namedTypes []*types.Named
 }
 
-func newCompare(p Printer, pkgInfo *loader.PackageInfo, prefix string, calls []*ast.CallExpr) (*compare, error) {
-	qual := types.RelativeTo(pkgInfo.Pkg)
+func newCompare(p Printer, pkgInfo *PackageInfo, prefix string, calls []*ast.CallExpr) (*compare, error) {
+	qual := types.RelativeTo(pkgInfo.Pkg())
 	typesMap := newTypesMap(qual, prefix)
+	var namedTypes []*types.Named
 
 	for _, call := range calls {
-		fn, ok := call.Fun.(*ast.Ident)
-		if !ok {
+		// call.Fun is a plain *ast.Ident for non-generic calls, but an
+		// *ast.IndexExpr (deriveCompareList[int](a, b)) or *ast.IndexListExpr
+		// (two or more explicit type arguments) for an instantiated generic
+		// call. Unwrap it so generic call sites are recognized the same way
+		// as non-generic ones; the concrete instantiation itself is still
+		// picked up below via pkgInfo.TypeOf(call.Args[0]).
+		var fn *ast.Ident
+		switch f := call.Fun.(type) {
+		case *ast.Ident:
+			fn = f
+		case *ast.IndexExpr:
+			ident, ok := f.X.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			fn = ident
+		case *ast.IndexListExpr:
+			ident, ok := f.X.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			fn = ident
+		default:
 			continue
 		}
 		if !strings.HasPrefix(fn.Name, prefix) {
 			continue
 		}
-		if len(call.Args) != 2 {
+		// A call may carry trailing comparator arguments beyond the two
+		// values being compared, e.g. deriveCompareList(a, b, cmp) when the
+		// element type is itself still a type parameter (see
+		// setupTypeParams); only Args[0] and Args[1] matter for type
+		// resolution here.
+		if len(call.Args) < 2 {
 			return nil, fmt.Errorf("%s does not have two arguments\n", fn.Name)
 		}
 		t0 := pkgInfo.TypeOf(call.Args[0])
@@ -60,6 +103,9 @@ func newCompare(p Printer, pkgInfo *loader.PackageInfo, prefix string, calls []*
 		if err := typesMap.SetFuncName(t0, fn.Name); err != nil {
 			return nil, err
 		}
+		if named, ok := namedOf(t0); ok {
+			namedTypes = append(namedTypes, named)
+		}
 	}
 	return &compare{
 		TypesMap:   typesMap,
@@ -67,9 +113,23 @@ func newCompare(p Printer, pkgInfo *loader.PackageInfo, prefix string, calls []*
 		printer:    p,
 		bytesPkg:   p.NewImport("bytes"),
 		stringsPkg: p.NewImport("strings"),
+		mathPkg:    p.NewImport("math"),
+		reflectPkg: p.NewImport("reflect"),
+		namedTypes: namedTypes,
 	}, nil
 }
 
+// namedOf unwraps a pointer to get at the *types.Named underneath it, if any,
+// so that both T and *T call-site arguments register T as a concrete type
+// that interfaceCompare can dispatch to.
+func namedOf(typ types.Type) (*types.Named, bool) {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+	named, ok := typ.(*types.Named)
+	return named, ok
+}
+
 func (this *compare) Generate() error {
 	for _, typ := range this.ToGenerate() {
 		if err := this.genFuncFor(typ); err != nil {
@@ -87,8 +147,14 @@ func (this *compare) genFuncFor(typ types.Type) error {
 	p := this.printer
 	this.Generating(typ)
 	typeStr := types.TypeString(typ, this.qual)
+	tparams, cmpParams := this.setupTypeParams(typ)
 	p.P("")
-	p.P("func %s(this, that %s) int {", this.GetFuncName(typ), typeStr)
+	if len(tparams) > 0 {
+		p.P("func %s[%s](this, that %s, %s) int {",
+			this.GetFuncName(typ), strings.Join(tparams, ", "), typeStr, strings.Join(cmpParams, ", "))
+	} else {
+		p.P("func %s(this, that %s) int {", this.GetFuncName(typ), typeStr)
+	}
 	p.In()
 	switch ttyp := typ.Underlying().(type) {
 	case *types.Pointer:
@@ -114,7 +180,52 @@ func (this *compare) genFuncFor(typ types.Type) error {
 		case types.String:
 			p.P("return %s.Compare(this, that)", this.stringsPkg())
 		case types.Complex128, types.Complex64:
-			p.P("return 0 //TODO")
+			m := this.mathPkg()
+			p.P("thisR, thatR := real(this), real(that)")
+			p.P("thisRNaN, thatRNaN := %s.IsNaN(float64(thisR)), %s.IsNaN(float64(thatR))", m, m)
+			p.P("if thisRNaN && !thatRNaN {")
+			p.In()
+			p.P("return 1")
+			p.Out()
+			p.P("}")
+			p.P("if !thisRNaN && thatRNaN {")
+			p.In()
+			p.P("return -1")
+			p.Out()
+			p.P("}")
+			p.P("if !thisRNaN && !thatRNaN && thisR != thatR {")
+			p.In()
+			p.P("if thisR < thatR {")
+			p.In()
+			p.P("return -1")
+			p.Out()
+			p.P("}")
+			p.P("return 1")
+			p.Out()
+			p.P("}")
+			p.P("thisI, thatI := imag(this), imag(that)")
+			p.P("thisINaN, thatINaN := %s.IsNaN(float64(thisI)), %s.IsNaN(float64(thatI))", m, m)
+			p.P("if thisINaN && !thatINaN {")
+			p.In()
+			p.P("return 1")
+			p.Out()
+			p.P("}")
+			p.P("if !thisINaN && thatINaN {")
+			p.In()
+			p.P("return -1")
+			p.Out()
+			p.P("}")
+			p.P("if !thisINaN && !thatINaN && thisI != thatI {")
+			p.In()
+			p.P("if thisI < thatI {")
+			p.In()
+			p.P("return -1")
+			p.Out()
+			p.P("}")
+			p.P("return 1")
+			p.Out()
+			p.P("}")
+			p.P("return 0")
 		case types.Bool:
 			p.P("if this == that {")
 			p.In()
@@ -291,15 +402,68 @@ func (this *compare) genFuncFor(typ types.Type) error {
 	}
 	p.Out()
 	p.P("}")
+	this.typeParamCmp = nil
 	return nil
 }
 
+// setupTypeParams detects whether typ still has type parameters that need
+// threading through as comparator arguments, and if so returns the "T
+// any"-style type parameter list and the "cmpT func(T, T) int" comparator
+// parameters its generated function needs to accept. field uses
+// this.typeParamCmp, which it populates as a side effect, to dispatch
+// comparisons of T-typed elements to the matching comparator parameter.
+//
+// This fires in two cases: typ is the generic declaration itself (e.g. List
+// in type List[T any] []T, where TypeArgs is empty), or typ is an
+// instantiation whose type argument is itself a type parameter rather than a
+// concrete type, which happens when a deriveCompare call is made from inside
+// another generic function (e.g. deriveCompareList(a, b, cmp) on a List[T]
+// receiver inside Merge[T any]). A fully concrete instantiation such as
+// List[int] has non-empty TypeArgs containing no *types.TypeParam, so it
+// falls through untouched.
+func (this *compare) setupTypeParams(typ types.Type) (tparams []string, cmpParams []string) {
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return nil, nil
+	}
+	n := named.TypeParams().Len()
+	if n == 0 {
+		return nil, nil
+	}
+	args := named.TypeArgs()
+	typeParamCmp := make(map[*types.TypeParam]string, n)
+	for i := 0; i < n; i++ {
+		tparam := named.TypeParams().At(i)
+		if args.Len() > i {
+			arg, ok := args.At(i).(*types.TypeParam)
+			if !ok {
+				// Instantiated with a concrete type argument; nothing to thread.
+				continue
+			}
+			tparam = arg
+		}
+		name := tparam.Obj().Name()
+		cmpName := "cmp" + name
+		typeParamCmp[tparam] = cmpName
+		tparams = append(tparams, fmt.Sprintf("%s any", name))
+		cmpParams = append(cmpParams, fmt.Sprintf("%s func(%s, %s) int", cmpName, name, name))
+	}
+	if len(tparams) == 0 {
+		return nil, nil
+	}
+	this.typeParamCmp = typeParamCmp
+	return tparams, cmpParams
+}
+
 func (this *compare) field(thisField, thatField string, fieldType types.Type) (string, error) {
 	switch typ := fieldType.(type) {
 	case *types.Basic:
 		if typ.Kind() == types.String {
 			return fmt.Sprintf("%s.Compare(%s, %s)", this.stringsPkg(), thisField, thatField), nil
 		}
+		if typ.Kind() == types.Complex64 || typ.Kind() == types.Complex128 {
+			return this.complexCompare(thisField, thatField), nil
+		}
 		return fmt.Sprintf("%s(%s, %s)", this.GetFuncName(fieldType), thisField, thatField), nil
 	case *types.Pointer:
 		ref := typ.Elem()
@@ -316,7 +480,153 @@ func (this *compare) field(thisField, thatField string, fieldType types.Type) (s
 		return fmt.Sprintf("%s(%s, %s)", this.GetFuncName(typ), thisField, thatField), nil
 	case *types.Named:
 		return fmt.Sprintf("%s.Compare(&%s)", thisField, thatField), nil
-	default: // *Chan, *Tuple, *Signature, *Interface, *types.Basic.Kind() == types.UntypedNil, *Struct
+	case *types.TypeParam:
+		cmpName, ok := this.typeParamCmp[typ]
+		if !ok {
+			return "", fmt.Errorf("unsupported field type %#v: type parameter %s is not in scope", fieldType, typ.Obj().Name())
+		}
+		return fmt.Sprintf("%s(%s, %s)", cmpName, thisField, thatField), nil
+	case *types.Struct:
+		return this.structCompare(thisField, thatField, typ)
+	case *types.Interface:
+		return this.interfaceCompare(thisField, thatField, typ), nil
+	case *types.Chan:
+		return this.chanCompare(thisField, thatField), nil
+	case *types.Signature:
+		// Functions carry no comparable identity in Go (they cannot even be
+		// compared with ==, except to nil), so there is no order to derive.
+		return "", fmt.Errorf("unsupported field type %#v: functions are not comparable", fieldType)
+	default: // *Tuple, *types.Basic.Kind() == types.UntypedNil
 		return "", fmt.Errorf("unsupported field type %#v", fieldType)
 	}
 }
+
+// structCompare returns an inline expression that compares an anonymous
+// (non-named) struct field by comparing its fields in turn, the same way
+// genFuncFor does for a named struct type, short-circuiting on the first
+// field that differs.
+func (this *compare) structCompare(thisField, thatField string, typ *types.Struct) (string, error) {
+	var buf strings.Builder
+	buf.WriteString("func() int {\n")
+	for i := 0; i < typ.NumFields(); i++ {
+		f := typ.Field(i)
+		thisF := thisField + "." + f.Name()
+		thatF := thatField + "." + f.Name()
+		cmpStr, err := this.field(thisF, thatF, f.Type())
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "if c := %s; c != 0 {\nreturn c\n}\n", cmpStr)
+	}
+	buf.WriteString("return 0\n}()")
+	return buf.String(), nil
+}
+
+// interfaceCompare returns an inline expression that orders two values of an
+// interface-typed field first by nilness, then by the name of their dynamic
+// type (so values of different concrete types always have a well-defined, if
+// arbitrary, order), and then, for every concrete type seen at this
+// program's deriveCompare call sites that implements the interface and has a
+// derived Compare method, dispatches to it via a type switch-like chain of
+// type assertions once both sides share that type. Every derived Compare
+// method has a pointer receiver (see the *types.Named case of field), so
+// both a value dynamic type (T) and a pointer dynamic type (*T) are tried:
+// whichever one implements iface is the one that can actually be stored in
+// the field, and the value-typed assertion takes the address of its local
+// before calling Compare. The second assertion on each side uses the
+// comma-ok form: thisType == thatType only proves the two reflect type
+// strings match, which could in principle be two distinct same-named types
+// from different packages, so thatField is not guaranteed to share
+// thisField's dynamic type.
+func (this *compare) interfaceCompare(thisField, thatField string, iface *types.Interface) string {
+	var buf strings.Builder
+	buf.WriteString("func() int {\n")
+	fmt.Fprintf(&buf, "if %s == nil {\n", thisField)
+	fmt.Fprintf(&buf, "if %s == nil {\nreturn 0\n}\nreturn -1\n}\n", thatField)
+	fmt.Fprintf(&buf, "if %s == nil {\nreturn 1\n}\n", thatField)
+	fmt.Fprintf(&buf, "thisType, thatType := %s.TypeOf(%s).String(), %s.TypeOf(%s).String()\n",
+		this.reflectPkg(), thisField, this.reflectPkg(), thatField)
+	buf.WriteString("if thisType != thatType {\n")
+	buf.WriteString("if thisType < thatType {\nreturn -1\n}\nreturn 1\n}\n")
+	for _, named := range this.namedTypes {
+		ptr := types.NewPointer(named)
+		if obj, _, _ := types.LookupFieldOrMethod(ptr, false, named.Obj().Pkg(), "Compare"); obj == nil {
+			continue
+		} else if _, ok := obj.(*types.Func); !ok {
+			continue
+		}
+		if types.Implements(named, iface) {
+			valStr := types.TypeString(named, this.qual)
+			fmt.Fprintf(&buf, "if thisV, ok := %s.(%s); ok {\n", thisField, valStr)
+			fmt.Fprintf(&buf, "if thatV, ok := %s.(%s); ok {\n", thatField, valStr)
+			buf.WriteString("return thisV.Compare(&thatV)\n")
+			buf.WriteString("}\n")
+			buf.WriteString("}\n")
+		}
+		if types.Implements(ptr, iface) {
+			ptrStr := types.TypeString(ptr, this.qual)
+			fmt.Fprintf(&buf, "if thisV, ok := %s.(%s); ok {\n", thisField, ptrStr)
+			fmt.Fprintf(&buf, "if thatV, ok := %s.(%s); ok {\n", thatField, ptrStr)
+			buf.WriteString("return thisV.Compare(thatV)\n")
+			buf.WriteString("}\n")
+			buf.WriteString("}\n")
+		}
+	}
+	buf.WriteString("return 0\n}()")
+	return buf.String()
+}
+
+// chanCompare returns an inline expression that orders two channel-typed
+// fields by the identity of their underlying channel header, since channels
+// support no ordering operators of their own.
+func (this *compare) chanCompare(thisField, thatField string) string {
+	r := this.reflectPkg()
+	return fmt.Sprintf(`func() int {
+		thisPtr, thatPtr := %s.ValueOf(%s).Pointer(), %s.ValueOf(%s).Pointer()
+		if thisPtr == thatPtr {
+			return 0
+		}
+		if thisPtr < thatPtr {
+			return -1
+		}
+		return 1
+	}()`, r, thisField, r, thatField)
+}
+
+// complexCompare returns an inline, allocation-free expression that orders
+// two complex values lexicographically by real part and then imaginary part,
+// treating NaN as greater than any non-NaN value and equal to another NaN.
+func (this *compare) complexCompare(thisField, thatField string) string {
+	m := this.mathPkg()
+	return fmt.Sprintf(`func() int {
+		thisR, thatR := real(%s), real(%s)
+		thisRNaN, thatRNaN := %s.IsNaN(float64(thisR)), %s.IsNaN(float64(thatR))
+		if thisRNaN && !thatRNaN {
+			return 1
+		}
+		if !thisRNaN && thatRNaN {
+			return -1
+		}
+		if !thisRNaN && !thatRNaN && thisR != thatR {
+			if thisR < thatR {
+				return -1
+			}
+			return 1
+		}
+		thisI, thatI := imag(%s), imag(%s)
+		thisINaN, thatINaN := %s.IsNaN(float64(thisI)), %s.IsNaN(float64(thatI))
+		if thisINaN && !thatINaN {
+			return 1
+		}
+		if !thisINaN && thatINaN {
+			return -1
+		}
+		if !thisINaN && !thatINaN && thisI != thatI {
+			if thisI < thatI {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	}()`, thisField, thatField, m, m, thisField, thatField, m, m)
+}
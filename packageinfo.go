@@ -0,0 +1,64 @@
+//  Copyright 2017 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// PackageInfo adapts a *packages.Package, as loaded via
+// golang.org/x/tools/go/packages, to the narrow surface the plugins need:
+// the type of an expression, the type-checked package, and its parsed
+// syntax files. It replaces the deprecated golang.org/x/tools/go/loader,
+// which does not understand Go modules, build constraints added after
+// 1.17, workspaces, or generics type instantiation, so that plugins only
+// need this thin adapter changed underneath them instead of being
+// rewritten against packages.Package directly.
+//
+// This only migrates the compare plugin's consumption point. The program
+// driver still needs to build a *packages.Package (via packages.Load) and
+// wrap it here instead of constructing a *loader.PackageInfo, and the other
+// plugins (gostring, equal, hash, sortedKeys, ...) still take
+// *loader.PackageInfo directly and need the same adapter threaded through
+// before the whole program compiles against go/packages. Neither the driver
+// nor any sibling plugin exists in this tree to migrate alongside it, so
+// that remaining work isn't tracked by a commit here; call it out when this
+// change is reviewed so it isn't mistaken for a finished migration.
+type PackageInfo struct {
+	pkg *packages.Package
+}
+
+// NewPackageInfo wraps a package loaded by packages.Load for use by the plugins.
+func NewPackageInfo(pkg *packages.Package) *PackageInfo {
+	return &PackageInfo{pkg: pkg}
+}
+
+// TypeOf returns the type of expr, as recorded by the package's type checker.
+func (this *PackageInfo) TypeOf(expr ast.Expr) types.Type {
+	return this.pkg.TypesInfo.TypeOf(expr)
+}
+
+// Pkg returns the type-checked package.
+func (this *PackageInfo) Pkg() *types.Package {
+	return this.pkg.Types
+}
+
+// Files returns the package's parsed syntax files.
+func (this *PackageInfo) Files() []*ast.File {
+	return this.pkg.Syntax
+}
@@ -0,0 +1,187 @@
+//  Copyright 2017 Walter Schulze
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package test
+
+import (
+	"math"
+	"testing"
+)
+
+// StructWithComplexField has a complex128 field, exercising the compare
+// plugin's lexicographic real-then-imaginary ordering, including its
+// NaN handling.
+type StructWithComplexField struct {
+	Field1 complex128
+}
+
+func TestCompareStructWithComplexField(t *testing.T) {
+	a := &StructWithComplexField{Field1: complex(1, 2)}
+	b := &StructWithComplexField{Field1: complex(1, 3)}
+	// Ordinary ordering: same real part, smaller imaginary part sorts first.
+	if c := deriveCompareStructWithComplexField(a, b); c >= 0 {
+		t.Fatalf("got %d, want < 0", c)
+	}
+	if c := deriveCompareStructWithComplexField(a, a); c != 0 {
+		t.Fatalf("got %d, want 0", c)
+	}
+	if c := deriveCompareStructWithComplexField(b, a); c <= 0 {
+		t.Fatalf("got %d, want > 0", c)
+	}
+
+	// NaN vs non-NaN: NaN is always greater, regardless of the imaginary part.
+	nan := &StructWithComplexField{Field1: complex(math.NaN(), 0)}
+	if c := deriveCompareStructWithComplexField(a, nan); c >= 0 {
+		t.Fatalf("got %d, want < 0", c)
+	}
+	if c := deriveCompareStructWithComplexField(nan, a); c <= 0 {
+		t.Fatalf("got %d, want > 0", c)
+	}
+
+	// NaN vs NaN, differing by imaginary part: real parts are equal (both
+	// NaN), so the order falls through to the imaginary part comparison.
+	nanLo := &StructWithComplexField{Field1: complex(math.NaN(), 1)}
+	nanHi := &StructWithComplexField{Field1: complex(math.NaN(), 2)}
+	if c := deriveCompareStructWithComplexField(nanLo, nanHi); c >= 0 {
+		t.Fatalf("got %d, want < 0", c)
+	}
+	if c := deriveCompareStructWithComplexField(nanHi, nanLo); c <= 0 {
+		t.Fatalf("got %d, want > 0", c)
+	}
+	if c := deriveCompareStructWithComplexField(nanLo, nanLo); c != 0 {
+		t.Fatalf("got %d, want 0", c)
+	}
+}
+
+// StructWithInterfaceField has an interface field, exercising the
+// compare plugin's dynamic-type-name-then-dispatch ordering for
+// interface-typed fields.
+type StructWithInterfaceField struct {
+	Field1 Stringer
+	Field2 int
+}
+
+type Stringer interface {
+	String() string
+}
+
+type ConcreteStringerA struct {
+	Value int
+}
+
+func (this ConcreteStringerA) String() string { return "a" }
+
+func (this *ConcreteStringerA) Compare(that *ConcreteStringerA) int {
+	return deriveCompareConcreteStringerA(this, that)
+}
+
+type ConcreteStringerB struct {
+	Value int
+}
+
+func (this ConcreteStringerB) String() string { return "b" }
+
+func (this *ConcreteStringerB) Compare(that *ConcreteStringerB) int {
+	return deriveCompareConcreteStringerB(this, that)
+}
+
+func TestCompareStructWithStructFieldWithoutEqualMethod(t *testing.T) {
+	a := &StructWithStructFieldWithoutEqualMethod{}
+	a.Field1.Field1 = 1
+	b := &StructWithStructFieldWithoutEqualMethod{}
+	b.Field1.Field1 = 2
+	if c := deriveCompareStructWithStructFieldWithoutEqualMethod(a, b); c >= 0 {
+		t.Fatalf("got %d, want < 0", c)
+	}
+	if c := deriveCompareStructWithStructFieldWithoutEqualMethod(a, a); c != 0 {
+		t.Fatalf("got %d, want 0", c)
+	}
+	if c := deriveCompareStructWithStructFieldWithoutEqualMethod(b, a); c <= 0 {
+		t.Fatalf("got %d, want > 0", c)
+	}
+}
+
+// List is a generic slice type, exercising the compare plugin's handling of
+// an instantiated generic call site: go/types substitutes T with the
+// concrete type argument before the plugin ever sees it, so List[int]
+// derives exactly like a plain named slice type would.
+type List[T any] []T
+
+func TestCompareListOfInt(t *testing.T) {
+	a := List[int]{1, 2, 3}
+	b := List[int]{1, 2, 4}
+	if c := deriveCompareList[int](a, b); c >= 0 {
+		t.Fatalf("got %d, want < 0", c)
+	}
+	if c := deriveCompareList[int](a, a); c != 0 {
+		t.Fatalf("got %d, want 0", c)
+	}
+	if c := deriveCompareList[int](b, a); c <= 0 {
+		t.Fatalf("got %d, want > 0", c)
+	}
+}
+
+// mergeSorted is a generic function that makes a deriveCompare call site on
+// List[T] for its own type parameter T, rather than a fully monomorphized
+// type such as List[int]: T is only resolved to a concrete type once
+// mergeSorted itself is instantiated, so deriveCompareList must thread T
+// through as a type parameter and accept a caller-supplied comparator for it.
+func mergeSorted[T any](a, b List[T], cmp func(T, T) int) int {
+	return deriveCompareList(a, b, cmp)
+}
+
+func TestCompareListFromGenericCaller(t *testing.T) {
+	cmp := func(a, b int) int { return a - b }
+	a := List[int]{1, 2, 3}
+	b := List[int]{1, 2, 4}
+	if c := mergeSorted(a, b, cmp); c >= 0 {
+		t.Fatalf("got %d, want < 0", c)
+	}
+	if c := mergeSorted(a, a, cmp); c != 0 {
+		t.Fatalf("got %d, want 0", c)
+	}
+	if c := mergeSorted(b, a, cmp); c <= 0 {
+		t.Fatalf("got %d, want > 0", c)
+	}
+}
+
+func TestCompareStructWithInterfaceField(t *testing.T) {
+	a := &StructWithInterfaceField{Field1: &ConcreteStringerA{Value: 1}}
+	b := &StructWithInterfaceField{Field1: &ConcreteStringerB{Value: 1}}
+	// Different dynamic types are ordered by their type name, regardless of value.
+	if c := deriveCompareStructWithInterfaceField(a, b); c == 0 {
+		t.Fatalf("got 0, want a non-zero order between different dynamic types")
+	}
+	c := &StructWithInterfaceField{Field1: &ConcreteStringerA{Value: 2}}
+	// Same dynamic type dispatches to its derived Compare.
+	if got := deriveCompareStructWithInterfaceField(a, c); got >= 0 {
+		t.Fatalf("got %d, want < 0", got)
+	}
+	if got := deriveCompareStructWithInterfaceField(a, a); got != 0 {
+		t.Fatalf("got %d, want 0", got)
+	}
+}
+
+func TestCompareStructWithInterfaceFieldValueType(t *testing.T) {
+	// ConcreteStringerA's String method has a value receiver, so it can be
+	// stored directly in the interface field, not just through a pointer.
+	a := &StructWithInterfaceField{Field1: ConcreteStringerA{Value: 1}}
+	b := &StructWithInterfaceField{Field1: ConcreteStringerA{Value: 2}}
+	if got := deriveCompareStructWithInterfaceField(a, b); got >= 0 {
+		t.Fatalf("got %d, want < 0", got)
+	}
+	if got := deriveCompareStructWithInterfaceField(a, a); got != 0 {
+		t.Fatalf("got %d, want 0", got)
+	}
+}